@@ -0,0 +1,118 @@
+package helper
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clockSkewTolerance is how far past a token's expiry the verifier still
+// accepts it, to absorb clock drift between the token issuer and this
+// host. It only shifts the clock backward: go-oidc's IDTokenVerifier uses a
+// single Now() for both the expiry check and any issued-at/not-before
+// check, so there is no single value that widens tolerance in both
+// directions at once - being more lenient past expiry necessarily makes
+// the issued-at/not-before check stricter.
+const clockSkewTolerance = 30 * time.Second
+
+type oidcFlagConfig struct {
+	Issuer   *string
+	Audience *string
+	JWKSURL  *string
+}
+
+var authRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "exporter_auth_requests_total",
+	Help: "Number of requests to the metrics endpoint by authentication result.",
+}, []string{"result"})
+
+// registerAuthMetrics registers the auth-result counter with the given
+// registry. It is called unconditionally, the counter simply stays at zero
+// when no OIDC issuer is configured.
+func registerAuthMetrics(r *prometheus.Registry) {
+	r.MustRegister(authRequestsTotal)
+}
+
+func (e *ExporterHelper) initAuthFlags() {
+	e.oidcConfig = &oidcFlagConfig{}
+	e.oidcConfig.Issuer = kingpin.Flag(
+		"web.auth.oidc.issuer", "OIDC issuer URL to validate metrics endpoint bearer tokens against. Disables auth if unset.",
+	).String()
+	e.oidcConfig.Audience = kingpin.Flag(
+		"web.auth.oidc.audience", "Expected audience (client id) of the bearer token. Skips the audience check if unset.",
+	).String()
+	e.oidcConfig.JWKSURL = kingpin.Flag(
+		"web.auth.oidc.jwks-url", "JWKS URL to fetch signing keys from. Defaults to the issuer's discovery document.",
+	).String()
+}
+
+// authMiddleware wraps handler with bearer-token validation against the
+// configured OIDC issuer. If no issuer is configured, handler is returned
+// unwrapped, keeping the metrics endpoint open the way it is today.
+func (e *ExporterHelper) authMiddleware(handler http.Handler) http.Handler {
+	if e.oidcConfig.Issuer == nil || *e.oidcConfig.Issuer == "" {
+		return handler
+	}
+
+	logger := e.Logger()
+
+	verifier, err := e.newOIDCVerifier(context.Background())
+	if err != nil {
+		level.Error(logger).Log("msg", "could not set up OIDC verifier", "err", err)
+		os.Exit(1)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			authRequestsTotal.WithLabelValues("missing_token").Inc()
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		if _, err := verifier.Verify(r.Context(), token); err != nil {
+			level.Debug(logger).Log("msg", "rejected request with invalid bearer token", "err", err)
+			authRequestsTotal.WithLabelValues("invalid_token").Inc()
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		authRequestsTotal.WithLabelValues("success").Inc()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (e *ExporterHelper) newOIDCVerifier(ctx context.Context) (*oidc.IDTokenVerifier, error) {
+	config := &oidc.Config{
+		ClientID:          *e.oidcConfig.Audience,
+		SkipClientIDCheck: e.oidcConfig.Audience == nil || *e.oidcConfig.Audience == "",
+		Now:               func() time.Time { return time.Now().Add(-clockSkewTolerance) },
+	}
+
+	if e.oidcConfig.JWKSURL != nil && *e.oidcConfig.JWKSURL != "" {
+		keySet := oidc.NewRemoteKeySet(ctx, *e.oidcConfig.JWKSURL)
+		return oidc.NewVerifier(*e.oidcConfig.Issuer, keySet, config), nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, *e.oidcConfig.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Verifier(config), nil
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(header, prefix)
+}
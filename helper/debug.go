@@ -0,0 +1,99 @@
+package helper
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log/level"
+)
+
+// debugShutdownTimeout bounds how long the debug server waits for
+// in-flight profile pulls to finish once a shutdown signal arrives.
+const debugShutdownTimeout = 5 * time.Second
+
+func (e *ExporterHelper) initDebugFlags() {
+	e.debugListenAddress = kingpin.Flag(
+		"web.debug-listen-address",
+		"Address to serve pprof, expvar and a GC trigger on, isolated from the metrics listener(s). Disabled if unset.",
+	).String()
+}
+
+// serveDebugEndpoints starts a second, independent http.Server exposing
+// net/http/pprof, expvar's /debug/vars, and a /debug/gc trigger, so that
+// pulling a profile never competes with scrapes on the main listener(s).
+// --web.debug-listen-address accepts a unix socket path the same way
+// --web.listen-address does. It is a no-op if --web.debug-listen-address is
+// unset.
+//
+// It deliberately does not go through createListener: that function installs
+// its own SIGTERM handler that removes the unix socket and calls os.Exit(1)
+// immediately, which would race the graceful server.Shutdown below and
+// could tear the process down before it finishes. A single signal handler
+// here does both in the right order instead.
+func (e *ExporterHelper) serveDebugEndpoints() {
+	if e.debugListenAddress == nil || *e.debugListenAddress == "" {
+		return
+	}
+
+	logger := e.Logger()
+	address := *e.debugListenAddress
+
+	listenType := "tcp"
+	if isUnixSocketAddress(address) {
+		listenType = "unix"
+	}
+
+	listener, err := net.Listen(listenType, address)
+	if err != nil {
+		level.Error(logger).Log("msg", "could not create debug listener", "err", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/gc", func(w http.ResponseWriter, r *http.Request) {
+		runtime.GC()
+		debug.FreeOSMemory()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	server := &http.Server{Handler: mux}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), debugShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			level.Warn(logger).Log("msg", "could not gracefully shut down debug server", "err", err)
+		}
+		if listenType == "unix" {
+			if err := os.Remove(address); err != nil {
+				level.Warn(logger).Log("msg", "could not remove debug unix socket", "address", address, "err", err)
+			}
+		}
+	}()
+
+	go func() {
+		level.Info(logger).Log("msg", "Starting debug server", "address", address)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			level.Error(logger).Log("msg", "debug server stopped unexpectedly", "err", err)
+		}
+	}()
+}
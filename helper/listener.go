@@ -0,0 +1,75 @@
+package helper
+
+import (
+	"errors"
+	"net"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/coreos/go-systemd/activation"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
+)
+
+// ListenerProvider is a pluggable source of net.Listeners for
+// ExporterHelper.ListenAndServe. Register one with
+// ExporterHelper.RegisterListener before InitFlags is called so its own
+// flags get parsed alongside the built-in ones.
+type ListenerProvider interface {
+	// InitFlags registers the kingpin flags this provider needs, if any.
+	InitFlags()
+	// CreateListeners returns the listeners this provider wants served. A
+	// provider that isn't configured (e.g. its listen address flag is
+	// unset) should return a nil slice and a nil error rather than an
+	// error, so it can simply be skipped.
+	CreateListeners(logger log.Logger) ([]net.Listener, error)
+	// IsExclusive reports whether, once it has produced listeners, this
+	// provider should be the only one served - the way "--web.ziti.only"
+	// takes over the whole process today.
+	IsExclusive() bool
+}
+
+// tcpListenerProvider covers the original listener story: plain TCP/unix
+// listeners from --web.listen-address, or, if --web.systemd-socket is set,
+// systemd socket activation.
+type tcpListenerProvider struct {
+	helper *ExporterHelper
+}
+
+func (p *tcpListenerProvider) InitFlags() {
+	p.helper.toolkitFlags = webflag.AddFlags(kingpin.CommandLine, p.helper.DefaultAddress)
+}
+
+func (p *tcpListenerProvider) CreateListeners(logger log.Logger) ([]net.Listener, error) {
+	flags := p.helper.toolkitFlags
+
+	if flags.WebSystemdSocket != nil && *flags.WebSystemdSocket {
+		level.Info(logger).Log("msg", "Listening on systemd activated listeners instead of port listeners.")
+		listeners, err := activation.Listeners()
+		if err != nil {
+			return nil, err
+		}
+		if len(listeners) < 1 {
+			return nil, errors.New("no socket activation file descriptors found")
+		}
+		return listeners, nil
+	}
+
+	if flags.WebListenAddresses == nil || len(*flags.WebListenAddresses) == 0 {
+		return nil, nil
+	}
+
+	listeners := make([]net.Listener, 0, len(*flags.WebListenAddresses))
+	for _, address := range *flags.WebListenAddresses {
+		listener, err := p.helper.createListener(address)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+func (p *tcpListenerProvider) IsExclusive() bool {
+	return false
+}
@@ -0,0 +1,106 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/go-kit/log"
+)
+
+// InProcessListenerProvider is an in-memory, bufconn-style ListenerProvider
+// meant for tests: it never touches a real socket, so a test can drive
+// ExporterHelper.ListenAndServe end-to-end and reach it through Dial
+// without binding a port.
+type InProcessListenerProvider struct {
+	mu       sync.Mutex
+	listener *inProcessListener
+}
+
+// NewInProcessListenerProvider creates a provider with no listener yet - one
+// is created on the next CreateListeners call, i.e. on the next
+// ListenAndServe.
+func NewInProcessListenerProvider() *InProcessListenerProvider {
+	return &InProcessListenerProvider{}
+}
+
+// InitFlags implements ListenerProvider. It has no flags of its own, tests
+// wire it up directly via RegisterListener.
+func (p *InProcessListenerProvider) InitFlags() {}
+
+// CreateListeners implements ListenerProvider.
+func (p *InProcessListenerProvider) CreateListeners(logger log.Logger) ([]net.Listener, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listener = newInProcessListener()
+	return []net.Listener{p.listener}, nil
+}
+
+// IsExclusive implements ListenerProvider.
+func (p *InProcessListenerProvider) IsExclusive() bool {
+	return false
+}
+
+// Dial connects to the listener created by the most recent CreateListeners
+// call, the way a scrape client would connect over TCP.
+func (p *InProcessListenerProvider) Dial(ctx context.Context) (net.Conn, error) {
+	p.mu.Lock()
+	listener := p.listener
+	p.mu.Unlock()
+
+	if listener == nil {
+		return nil, errors.New("in-process listener has not been created yet")
+	}
+	return listener.dial(ctx)
+}
+
+type inProcessAddr struct{}
+
+func (inProcessAddr) Network() string { return "in-process" }
+func (inProcessAddr) String() string  { return "in-process" }
+
+// inProcessListener is a minimal net.Listener backed by net.Pipe, handing
+// accepted connections out over a channel the way bufconn does.
+type inProcessListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newInProcessListener() *inProcessListener {
+	return &inProcessListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *inProcessListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *inProcessListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *inProcessListener) Addr() net.Addr {
+	return inProcessAddr{}
+}
+
+func (l *inProcessListener) dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
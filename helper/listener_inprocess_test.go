@@ -0,0 +1,76 @@
+package helper
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+// TestListenAndServeInProcess drives ExporterHelper.ListenAndServe through an
+// InProcessListenerProvider end-to-end, to exercise the bufconn-style
+// listener the way a scrape client would rather than leaving it unexercised.
+func TestListenAndServeInProcess(t *testing.T) {
+	h := NewHelper("test_exporter", "test exporter", "127.0.0.1:0")
+
+	inproc := NewInProcessListenerProvider()
+	h.RegisterListener("inprocess", inproc)
+
+	h.InitFlags()
+	if _, err := kingpin.CommandLine.Parse(nil); err != nil {
+		t.Fatalf("could not parse default flags: %v", err)
+	}
+
+	handler := h.CreatePromHandler(nil)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- h.ListenAndServe(&http.Server{}, handler)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return inproc.Dial(ctx)
+			},
+		},
+	}
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	for i := 0; i < 50; i++ {
+		select {
+		case err := <-serveErr:
+			t.Fatalf("ListenAndServe exited early: %v", err)
+		default:
+		}
+
+		resp, err = client.Get("http://in-process" + *h.MetricsPath)
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("could not reach metrics endpoint over the in-process listener: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("could not read response body: %v", err)
+	}
+	if len(body) == 0 {
+		t.Fatal("expected a non-empty metrics response")
+	}
+}
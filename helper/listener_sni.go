@@ -0,0 +1,80 @@
+package helper
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+)
+
+// SNIListenerProvider multiplexes several TLS server names onto a single
+// listen address, picking the certificate to present from the ClientHello's
+// SNI instead of from exporter-toolkit's single web-config TLS section.
+// Register it under a name of your choosing via ExporterHelper.RegisterListener
+// and call AddCertificate for every server name before InitFlags is called.
+type SNIListenerProvider struct {
+	address      *string
+	flagName     string
+	certificates map[string]tls.Certificate
+	defaultName  string
+}
+
+// NewSNIListenerProvider creates a provider that listens on flagName (a new
+// kingpin flag this provider registers) and falls back to defaultCertName's
+// certificate for ClientHellos that don't carry a matching, or any, SNI.
+func NewSNIListenerProvider(flagName, defaultCertName string) *SNIListenerProvider {
+	return &SNIListenerProvider{
+		flagName:     flagName,
+		certificates: make(map[string]tls.Certificate),
+		defaultName:  defaultCertName,
+	}
+}
+
+// AddCertificate registers certFile/keyFile to be served for serverName.
+func (p *SNIListenerProvider) AddCertificate(serverName, certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	p.certificates[serverName] = cert
+	return nil
+}
+
+// InitFlags implements ListenerProvider.
+func (p *SNIListenerProvider) InitFlags() {
+	p.address = kingpin.Flag(
+		p.flagName, "Address to listen on for TLS connections multiplexed by server name.",
+	).String()
+}
+
+// CreateListeners implements ListenerProvider.
+func (p *SNIListenerProvider) CreateListeners(logger log.Logger) ([]net.Listener, error) {
+	if p.address == nil || *p.address == "" {
+		return nil, nil
+	}
+
+	tcpListener, err := net.Listen("tcp", *p.address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert, ok := p.certificates[hello.ServerName]; ok {
+				return &cert, nil
+			}
+			if cert, ok := p.certificates[p.defaultName]; ok {
+				return &cert, nil
+			}
+			return nil, fmt.Errorf("no certificate configured for server name %q", hello.ServerName)
+		},
+	}
+	return []net.Listener{tls.NewListener(tcpListener, tlsConfig)}, nil
+}
+
+// IsExclusive implements ListenerProvider.
+func (p *SNIListenerProvider) IsExclusive() bool {
+	return false
+}
@@ -0,0 +1,233 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/openziti/sdk-golang/ziti"
+)
+
+// zitiBindBackoffCap is the largest gap left between two bind attempts
+// while retrying within --web.ziti.bind-retry.
+const zitiBindBackoffCap = 30 * time.Second
+
+type zitiFlagConfig struct {
+	IdentityFile    *string
+	ServiceName     *string
+	ZitiOnly        *bool
+	EnrollmentJWT   *string
+	BindRetryBudget *time.Duration
+}
+
+// zitiListenerProvider is the built-in replacement for the former
+// helper/ziti.ZitiServerHelper package: it binds a Ziti service as a
+// net.Listener using the identity configured via --web.ziti.identity,
+// enrolling that identity first if it is missing and an enrollment JWT was
+// provided.
+type zitiListenerProvider struct {
+	helper *ExporterHelper
+	config zitiFlagConfig
+
+	mu  sync.Mutex
+	ctx ziti.Context
+}
+
+func (p *zitiListenerProvider) InitFlags() {
+	p.config.IdentityFile = kingpin.Flag(
+		"web.ziti.identity", "Path of the ziti identity json file. Ignored if path does not exist",
+	).Default("./identity.json").String()
+	p.config.ServiceName = kingpin.Flag(
+		"web.ziti.service-name", "Name of the service to bind to. If binding fails, stops in --web.ziti.only mode and otherwise skips the ziti listener until the next reload",
+	).Default(p.helper.ExporterName).String()
+	p.config.ZitiOnly = kingpin.Flag(
+		"web.ziti.only", "If it listens on the ziti network only. Requires a valid ziti config.",
+	).Default("false").Bool()
+	p.config.EnrollmentJWT = kingpin.Flag(
+		"web.ziti.enrollment-jwt", "Path to a one-time enrollment JWT. Used to create --web.ziti.identity if that file does not exist yet.",
+	).String()
+	p.config.BindRetryBudget = kingpin.Flag(
+		"web.ziti.bind-retry", "How long to keep retrying, with exponential backoff, to bind the ziti service before giving up. Use 0 to fail immediately, as before.",
+	).Default("0s").Duration()
+}
+
+func (p *zitiListenerProvider) CreateListeners(logger log.Logger) ([]net.Listener, error) {
+	listener := p.createZitiListener(logger)
+	if listener == nil {
+		return nil, nil
+	}
+	return []net.Listener{listener}, nil
+}
+
+func (p *zitiListenerProvider) IsExclusive() bool {
+	return p.config.ZitiOnly != nil && *p.config.ZitiOnly
+}
+
+func (p *zitiListenerProvider) createZitiListener(logger log.Logger) net.Listener {
+	if err := p.ensureEnrolled(logger); err != nil {
+		level.Error(logger).Log("msg", "could not enroll ziti identity", "err", err)
+		return nil
+	}
+
+	if stat, err := os.Stat(*p.config.IdentityFile); err != nil || stat.IsDir() {
+		if err != nil {
+			level.Warn(logger).Log("err", err)
+		}
+		level.Warn(logger).Log("msg", "identity file likely not accessible - ignoring")
+		return nil
+	}
+
+	// Get identity config
+	cfg, err := ziti.NewConfigFromFile(*p.config.IdentityFile)
+	if err != nil {
+		level.Error(logger).Log("msg", "could not read ziti identity config", "err", err)
+		return nil
+	}
+
+	ctx, err := ziti.NewContext(cfg)
+	if err != nil {
+		level.Error(logger).Log("msg", "could not create ziti context", "err", err)
+		return nil
+	}
+
+	p.setContext(ctx)
+
+	options := ziti.ListenOptions{
+		ConnectTimeout: 5 * time.Minute,
+		MaxConnections: 3,
+	}
+
+	listener, err := p.bindWithRetry(ctx, &options, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "error binding service", "err", err)
+		return nil
+	}
+
+	level.Info(logger).Log("msg", "listening for requests", "service", p.config.ServiceName)
+	return listener
+}
+
+// bindWithRetry calls ctx.ListenWithOptions, retrying with exponential
+// backoff (capped at zitiBindBackoffCap) until it succeeds or the
+// --web.ziti.bind-retry budget is used up, instead of exiting the process
+// on the first failure.
+func (p *zitiListenerProvider) bindWithRetry(ctx ziti.Context, options *ziti.ListenOptions, logger log.Logger) (net.Listener, error) {
+	var budget time.Duration
+	if p.config.BindRetryBudget != nil {
+		budget = *p.config.BindRetryBudget
+	}
+	deadline := time.Now().Add(budget)
+	backoff := time.Second
+
+	for {
+		listener, err := ctx.ListenWithOptions(*p.config.ServiceName, options)
+		if err == nil {
+			return listener, nil
+		}
+		if budget <= 0 || time.Now().After(deadline) {
+			return nil, err
+		}
+
+		level.Warn(logger).Log("msg", "could not bind ziti service, retrying", "err", err, "retry_in", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > zitiBindBackoffCap {
+			backoff = zitiBindBackoffCap
+		}
+	}
+}
+
+// ensureEnrolled turns a one-time enrollment JWT into the identity file, if
+// that file doesn't exist yet and an enrollment JWT was configured. It is a
+// no-op otherwise, so unattended edge deployments only need to ship the JWT
+// once and can be redeployed afterwards without it.
+func (p *zitiListenerProvider) ensureEnrolled(logger log.Logger) error {
+	if p.config.EnrollmentJWT == nil || *p.config.EnrollmentJWT == "" {
+		return nil
+	}
+	if stat, err := os.Stat(*p.config.IdentityFile); err == nil && !stat.IsDir() {
+		return nil
+	}
+
+	level.Info(logger).Log("msg", "enrolling ziti identity", "jwt_path", *p.config.EnrollmentJWT, "identity", *p.config.IdentityFile)
+
+	token, err := os.ReadFile(*p.config.EnrollmentJWT)
+	if err != nil {
+		return fmt.Errorf("reading enrollment jwt: %w", err)
+	}
+
+	return ziti.Enroll(&ziti.EnrollmentFlags{
+		JwtString:  string(token),
+		OutputFile: *p.config.IdentityFile,
+	})
+}
+
+func (p *zitiListenerProvider) setContext(ctx ziti.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ctx = ctx
+}
+
+// enabled reports whether ziti is actually configured for this deployment:
+// either --web.ziti.only was requested, an enrollment JWT was given, or an
+// identity file already exists on disk. Plain TCP/unix deployments that
+// never touch any of these leave healthzHandler reporting "ok" instead of
+// perpetually "not_enrolled".
+func (p *zitiListenerProvider) enabled() bool {
+	if p.config.ZitiOnly != nil && *p.config.ZitiOnly {
+		return true
+	}
+	if p.config.EnrollmentJWT != nil && *p.config.EnrollmentJWT != "" {
+		return true
+	}
+	if p.config.IdentityFile != nil && *p.config.IdentityFile != "" {
+		if stat, err := os.Stat(*p.config.IdentityFile); err == nil && !stat.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// healthzHandler reports whether a ziti identity/API session is usable, so
+// orchestrators can tell "not enrolled" (no identity has ever loaded) apart
+// from "overlay down" (a loaded identity can't reach the controller). If
+// ziti isn't configured at all, it reports "ok" rather than penalizing
+// deployments that never use it.
+func (p *zitiListenerProvider) healthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.enabled() {
+			writeHealthz(w, http.StatusOK, "ok", "ziti is not configured")
+			return
+		}
+
+		p.mu.Lock()
+		ctx := p.ctx
+		p.mu.Unlock()
+
+		if ctx == nil {
+			writeHealthz(w, http.StatusServiceUnavailable, "not_enrolled", "no ziti identity has been loaded yet")
+			return
+		}
+
+		identity, err := ctx.GetCurrentIdentity()
+		if err != nil {
+			writeHealthz(w, http.StatusServiceUnavailable, "overlay_down", err.Error())
+			return
+		}
+
+		writeHealthz(w, http.StatusOK, "ok", identity.Name)
+	}
+}
+
+func writeHealthz(w http.ResponseWriter, status int, state, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"status": state, "detail": detail})
+}
@@ -9,13 +9,10 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
-	"time"
 
 	"github.com/alecthomas/kingpin/v2"
-	"github.com/coreos/go-systemd/activation"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	"github.com/openziti/sdk-golang/ziti"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	versionCollector "github.com/prometheus/client_golang/prometheus/collectors/version"
@@ -24,15 +21,8 @@ import (
 	"github.com/prometheus/common/promlog/flag"
 	"github.com/prometheus/common/version"
 	"github.com/prometheus/exporter-toolkit/web"
-	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
 )
 
-type zitiFlagConfig struct {
-	IdentityFile *string
-	ServiceName  *string
-	ZitiOnly     *bool
-}
-
 type ExporterHelper struct {
 	// what name to use for the exporter
 	// must be of format [a-ZA-Z0-9_], hyphens "-" are not allowed
@@ -57,8 +47,22 @@ type ExporterHelper struct {
 
 	toolkitFlags  *web.FlagConfig
 	promlogConfig *promlog.Config
-	zitiConfig    *zitiFlagConfig
+	oidcConfig    *oidcFlagConfig
+	queueConfig   *queueFlagConfig
 	logger        log.Logger
+
+	debugListenAddress *string
+
+	// listenerNames preserves registration order, listeners is keyed by
+	// name for RegisterListener overrides. See ListenerProvider.
+	listenerNames []string
+	listeners     map[string]ListenerProvider
+	// ziti is the built-in ziti provider, kept around so serveZitiWithReload
+	// can re-create its listener independently of the other providers.
+	ziti *zitiListenerProvider
+
+	// reloadSignal wakes up serveZitiWithReload, see watchForReload.
+	reloadSignal chan struct{}
 }
 
 func NewHelper(name, description, address string) ExporterHelper {
@@ -70,28 +74,67 @@ func NewHelper(name, description, address string) ExporterHelper {
 	}
 }
 
+// RegisterListener adds a ListenerProvider under name, which is used to
+// preserve registration order and to let callers override a provider (e.g.
+// the built-in "tcp" or "ziti" ones) by registering another one under the
+// same name before InitFlags is called.
+func (e *ExporterHelper) RegisterListener(name string, p ListenerProvider) {
+	if e.listeners == nil {
+		e.listeners = make(map[string]ListenerProvider)
+	}
+	if _, exists := e.listeners[name]; !exists {
+		e.listenerNames = append(e.listenerNames, name)
+	}
+	e.listeners[name] = p
+}
+
+// registerListenerIfAbsent is used for the built-in providers: it must not
+// clobber a provider a caller already registered under the same name.
+func (e *ExporterHelper) registerListenerIfAbsent(name string, p ListenerProvider) {
+	if e.listeners == nil {
+		e.listeners = make(map[string]ListenerProvider)
+	}
+	if _, exists := e.listeners[name]; exists {
+		return
+	}
+	e.listenerNames = append(e.listenerNames, name)
+	e.listeners[name] = p
+}
+
+// registerDefaultListeners wires up the built-in "tcp" and "ziti" providers,
+// unless a caller already registered its own under either name. A "ziti"
+// override is treated as a plain ListenerProvider: e.ziti is left nil, so
+// the reload/healthz machinery that is specific to the built-in
+// zitiListenerProvider simply doesn't run, rather than running against the
+// wrong provider.
+func (e *ExporterHelper) registerDefaultListeners() {
+	e.registerListenerIfAbsent("tcp", &tcpListenerProvider{helper: e})
+
+	if e.listeners == nil {
+		e.listeners = make(map[string]ListenerProvider)
+	}
+	if _, overridden := e.listeners["ziti"]; overridden {
+		return
+	}
+
+	e.ziti = &zitiListenerProvider{helper: e}
+	e.registerListenerIfAbsent("ziti", e.ziti)
+}
+
 func (e *ExporterHelper) InitFlags() {
 	e.MetricsPath = kingpin.Flag(
 		"web.telemetry-path", "Path under which to expose metrics",
 	).Default("/metrics").String()
 
-	e.toolkitFlags = webflag.AddFlags(kingpin.CommandLine, e.DefaultAddress)
-
 	e.promlogConfig = &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, e.promlogConfig)
 	kingpin.Version(version.Print(e.ExporterName))
 	kingpin.HelpFlag.Short('h')
 
-	e.zitiConfig = &zitiFlagConfig{}
-	e.zitiConfig.IdentityFile = kingpin.Flag(
-		"web.ziti.identity", "Path of the ziti identity json file. Ignored if path does not exist",
-	).Default("./identity.json").String()
-	e.zitiConfig.ServiceName = kingpin.Flag(
-		"web.ziti.service-name", "Name of the service to bind to. Stops if it wants to bind but does not exist",
-	).Default(e.ExporterName).String()
-	e.zitiConfig.ZitiOnly = kingpin.Flag(
-		"web.ziti.only", "If it listens on the ziti network only. Requires a valid ziti config.",
-	).Default("false").Bool()
+	e.registerDefaultListeners()
+	for _, name := range e.listenerNames {
+		e.listeners[name].InitFlags()
+	}
 
 	e.DisableExporterMetrics = kingpin.Flag(
 		"web.disable-exporter-metrics",
@@ -105,6 +148,10 @@ func (e *ExporterHelper) InitFlags() {
 		"web.max-requests",
 		"Maximum number of parallel scrape requests. Use 0 to disable.",
 	).Default("2").Int()
+
+	e.initAuthFlags()
+	e.initQueueFlags()
+	e.initDebugFlags()
 }
 
 func (e *ExporterHelper) Logger() log.Logger {
@@ -144,7 +191,10 @@ func (e *ExporterHelper) CreatePromHandler(collector prometheus.Collector) http.
 			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 		)
 	}
-	return handler
+	registerReloadMetrics(r)
+	registerAuthMetrics(r)
+	registerQueueMetrics(r)
+	return e.queueingHandler(handler, *e.MaxRequests)
 }
 
 // use the prometheus handler configured via flags
@@ -169,7 +219,10 @@ func (e *ExporterHelper) ListenAndServe(server *http.Server, promHandler http.Ha
 	level.Info(logger).Log("msg", "Starting "+e.ExporterName, "version", version.Info())
 	level.Info(logger).Log("msg", "Build context", "build_context", version.BuildContext())
 
-	e.HandlerSetter(*e.MetricsPath, promHandler)
+	e.HandlerSetter(*e.MetricsPath, e.authMiddleware(promHandler))
+	if e.ziti != nil {
+		e.HandlerSetter("/healthz", e.ziti.healthzHandler())
+	}
 
 	if *e.MetricsPath != "/" && *e.MetricsPath != "" && *e.LandingPage {
 		landingConfig := web.LandingConfig{
@@ -191,55 +244,25 @@ func (e *ExporterHelper) ListenAndServe(server *http.Server, promHandler http.Ha
 		e.HandlerSetter("/", landingPage)
 	}
 
+	e.serveDebugEndpoints()
+
 	if err := e.listenAndServe(server); !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 	return nil
 }
 
-func (e *ExporterHelper) CreateZitiListener() net.Listener {
-	options := ziti.ListenOptions{
-		ConnectTimeout: 5 * time.Minute,
-		MaxConnections: 3,
-	}
-
-	if stat, err := os.Stat(*e.zitiConfig.IdentityFile); err != nil || stat.IsDir() {
-		if err != nil {
-			level.Warn(e.logger).Log("err", err)
-		}
-		level.Warn(e.logger).Log("msg", "identity file likely not accessible - ignoring")
-		return nil
-	}
-
-	// Get identity config
-	cfg, err := ziti.NewConfigFromFile(*e.zitiConfig.IdentityFile)
-
-	if err != nil {
-		panic(err)
-	}
-
-	ctx, err := ziti.NewContext(cfg)
-
-	if err != nil {
-		panic(err)
-	}
-
-	listener, err := ctx.ListenWithOptions(*e.zitiConfig.ServiceName, &options)
-
-	if err != nil {
-		level.Error(e.logger).Log("msg", "error binding service", "err", err)
-		os.Exit(1)
-	}
-
-	level.Info(e.logger).Log("msg", "listening for requests", "service", e.zitiConfig.ServiceName)
-	return listener
+// isUnixSocketAddress reports whether address names a unix socket path
+// rather than a host:port, the same convention createListener and the
+// debug listener use to decide which net.Listen network to pass.
+func isUnixSocketAddress(address string) bool {
+	return strings.HasPrefix(address, "/") && (strings.HasSuffix(address, ".socket") || strings.HasSuffix(address, ".sock"))
 }
 
 func (e *ExporterHelper) createListener(address string) (net.Listener, error) {
 	listenType := "tcp"
 
-	// check if unix socket
-	if strings.HasPrefix(address, "/") && (strings.HasSuffix(address, ".socket") || strings.HasSuffix(address, ".sock")) {
+	if isUnixSocketAddress(address) {
 		listenType = "unix"
 
 		// Cleanup the sockfile.
@@ -263,50 +286,59 @@ func (e *ExporterHelper) createListener(address string) (net.Listener, error) {
 	return net.Listen(listenType, address)
 }
 
+// listenAndServe drives the registered ListenerProviders: the built-in ziti
+// provider (e.ziti, nil if a caller overrode "ziti" with their own
+// provider) is always served separately (see serveZitiWithReload) since it
+// owns its own reload loop, every other exclusive provider takes over the
+// server on its own, and the rest are aggregated into one web.ServeMultiple
+// call.
 func (e *ExporterHelper) listenAndServe(server *http.Server) error {
 	logger := e.Logger()
 
-	if *e.zitiConfig.ZitiOnly {
-		listener := e.CreateZitiListener()
+	e.reloadSignal = make(chan struct{}, 1)
+	e.watchForReload()
 
-		if listener == nil {
-			level.Error(logger).Log("msg", "could not create ziti listener in ziti only mode")
-			os.Exit(1)
-		}
-		return web.ServeMultiple([]net.Listener{listener}, server, e.toolkitFlags, logger)
+	if e.ziti != nil && e.ziti.IsExclusive() {
+		errCh := make(chan error, 1)
+		go e.serveZitiWithReload(server, errCh)
+		return <-errCh
 	}
 
-	if e.toolkitFlags.WebSystemdSocket == nil && (e.toolkitFlags.WebListenAddresses == nil || len(*e.toolkitFlags.WebListenAddresses) == 0) {
-		return web.ErrNoListeners
-	}
+	var listeners []net.Listener
 
-	if e.toolkitFlags.WebSystemdSocket != nil && *e.toolkitFlags.WebSystemdSocket {
-		level.Info(logger).Log("msg", "Listening on systemd activated listeners instead of port listeners.")
-		listeners, err := activation.Listeners()
+	for _, name := range e.listenerNames {
+		if e.ziti != nil && name == "ziti" && e.listeners[name] == ListenerProvider(e.ziti) {
+			continue
+		}
+
+		provider := e.listeners[name]
+		created, err := provider.CreateListeners(logger)
 		if err != nil {
 			return err
 		}
-		if len(listeners) < 1 {
-			return errors.New("no socket activation file descriptors found")
+		for _, l := range created {
+			defer l.Close()
 		}
-		return web.ServeMultiple(listeners, server, e.toolkitFlags, logger)
-	}
 
-	listeners := make([]net.Listener, 0, len(*e.toolkitFlags.WebListenAddresses))
-
-	for _, address := range *e.toolkitFlags.WebListenAddresses {
-		listener, err := e.createListener(address)
-		if err != nil {
-			return err
+		if provider.IsExclusive() && len(created) > 0 {
+			return web.ServeMultiple(created, server, e.toolkitFlags, logger)
 		}
-		defer listener.Close()
-		listeners = append(listeners, listener)
+		listeners = append(listeners, created...)
 	}
 
-	listener := e.CreateZitiListener()
+	if len(listeners) == 0 {
+		return web.ErrNoListeners
+	}
 
-	if listener != nil {
-		listeners = append(listeners, listener)
+	// the ziti listener runs in its own goroutine, on its own *http.Server,
+	// so a reload can close and re-open it independently of the always-on
+	// listeners above without two goroutines configuring the same
+	// *http.Server (TLS, timeouts, ...) concurrently in web.ServeMultiple.
+	// Nothing to do here if "ziti" was overridden with a non-built-in
+	// provider - it was already picked up by the aggregation loop above.
+	if e.ziti != nil {
+		go e.serveZitiWithReload(&http.Server{}, nil)
 	}
+
 	return web.ServeMultiple(listeners, server, e.toolkitFlags, logger)
 }
@@ -0,0 +1,168 @@
+package helper
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type queueFlagConfig struct {
+	MaxQueuedRequests *int
+	QueueTimeout      *time.Duration
+}
+
+var (
+	scrapeQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exporter_scrape_queue_depth",
+		Help: "Current number of scrape requests waiting for a free scrape slot.",
+	})
+	scrapeWaitSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "exporter_scrape_wait_seconds",
+		Help:    "Time scrape requests spent waiting in the queue before running or being rejected.",
+		Buckets: prometheus.DefBuckets,
+	})
+	scrapeRejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "exporter_scrape_rejected_total",
+		Help: "Number of scrape requests rejected instead of queued or run.",
+	}, []string{"reason"})
+)
+
+// registerQueueMetrics registers the scrape-queue metrics with the given
+// registry. It is called unconditionally, the metrics simply stay at zero
+// when queueing is disabled (the default, --web.max-queued-requests=0).
+func registerQueueMetrics(r *prometheus.Registry) {
+	r.MustRegister(scrapeQueueDepth, scrapeWaitSeconds, scrapeRejectedTotal)
+}
+
+func (e *ExporterHelper) initQueueFlags() {
+	e.queueConfig = &queueFlagConfig{}
+	e.queueConfig.MaxQueuedRequests = kingpin.Flag(
+		"web.max-queued-requests",
+		"Maximum number of scrape requests to queue once web.max-requests is saturated, instead of rejecting them immediately. Use 0 to disable queueing.",
+	).Default("0").Int()
+	e.queueConfig.QueueTimeout = kingpin.Flag(
+		"web.queue-timeout",
+		"Maximum time a scrape request waits in the queue for a free slot before being rejected.",
+	).Default("10s").Duration()
+}
+
+// scrapeSlots is a FIFO semaphore: acquire hands its slots out strictly in
+// the order they were requested. A bare buffered channel doesn't guarantee
+// that - once multiple goroutines are blocked sending to the same channel,
+// which one the Go scheduler wakes on the next receive is not required to
+// match arrival order, so a late scrape could jump a queued one under load.
+type scrapeSlots struct {
+	mu      sync.Mutex
+	free    int
+	waiting []chan struct{}
+}
+
+func newScrapeSlots(n int) *scrapeSlots {
+	return &scrapeSlots{free: n}
+}
+
+// acquire returns a channel that is closed once a slot has been granted,
+// immediately if one is free. release must be called exactly once per
+// channel returned here, whether or not it was ever granted.
+func (s *scrapeSlots) acquire() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	turn := make(chan struct{})
+	if s.free > 0 {
+		s.free--
+		close(turn)
+		return turn
+	}
+	s.waiting = append(s.waiting, turn)
+	return turn
+}
+
+// release gives up turn's slot: if turn was granted, its slot passes to
+// whoever has waited longest; if turn gave up before that (the caller timed
+// out while still queued), it is just dropped from the wait list, since it
+// never actually held a slot to hand on.
+func (s *scrapeSlots) release(turn chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-turn:
+	default:
+		for i, w := range s.waiting {
+			if w == turn {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				return
+			}
+		}
+	}
+
+	if len(s.waiting) > 0 {
+		next := s.waiting[0]
+		s.waiting = s.waiting[1:]
+		close(next)
+		return
+	}
+	s.free++
+}
+
+// queueingHandler wraps handler with a bounded FIFO queue in front of the
+// maxRequests concurrency limit also enforced by promhttp.HandlerOpts on
+// handler itself: instead of promhttp's immediate 503 once that limit is
+// hit, a caller waits in line up to --web.queue-timeout for a free slot. It
+// is a no-op if queueing is disabled or there is no concurrency limit to
+// queue for.
+func (e *ExporterHelper) queueingHandler(handler http.Handler, maxRequests int) http.Handler {
+	maxQueued := *e.queueConfig.MaxQueuedRequests
+	if maxRequests <= 0 || maxQueued <= 0 {
+		return handler
+	}
+
+	timeout := *e.queueConfig.QueueTimeout
+	slots := newScrapeSlots(maxRequests)
+	queue := make(chan struct{}, maxQueued)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case queue <- struct{}{}:
+		default:
+			scrapeRejectedTotal.WithLabelValues("queue_full").Inc()
+			retryAfter(w, timeout)
+			http.Error(w, "too many scrape requests queued", http.StatusServiceUnavailable)
+			return
+		}
+
+		scrapeQueueDepth.Inc()
+		start := time.Now()
+		turn := slots.acquire()
+		defer slots.release(turn)
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-turn:
+			scrapeQueueDepth.Dec()
+			<-queue
+		case <-timer.C:
+			scrapeQueueDepth.Dec()
+			<-queue
+			scrapeWaitSeconds.Observe(time.Since(start).Seconds())
+			scrapeRejectedTotal.WithLabelValues("timeout").Inc()
+			retryAfter(w, timeout)
+			http.Error(w, "timed out waiting for a free scrape slot", http.StatusServiceUnavailable)
+			return
+		}
+
+		scrapeWaitSeconds.Observe(time.Since(start).Seconds())
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func retryAfter(w http.ResponseWriter, timeout time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%.0f", timeout.Seconds()))
+}
@@ -0,0 +1,207 @@
+package helper
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+var (
+	configReloadSuccessTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exporter_config_reload_success_total",
+		Help: "Number of successful configuration reloads.",
+	})
+	configReloadFailureTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "exporter_config_reload_failure_total",
+		Help: "Number of failed configuration reloads.",
+	})
+	configReloadLastTimestampSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exporter_config_last_reload_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload.",
+	})
+)
+
+// registerReloadMetrics registers the config-reload counters/gauge with the
+// given registry. It is called unconditionally, the same way the version
+// collector is, since it describes the exporter itself and not the thing it
+// scrapes.
+func registerReloadMetrics(r *prometheus.Registry) {
+	r.MustRegister(configReloadSuccessTotal, configReloadFailureTotal, configReloadLastTimestampSeconds)
+}
+
+// watchForReload starts a background goroutine that triggers a reload on
+// SIGHUP, and, if a web config file and/or a ziti identity file are
+// configured, whenever fsnotify reports a change to one of them. This
+// mirrors the mapping-file watch that statsd_exporter does for its config.
+func (e *ExporterHelper) watchForReload() {
+	logger := e.Logger()
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		level.Warn(logger).Log("msg", "could not create file watcher, hot reload is limited to SIGHUP", "err", err)
+		watcher = nil
+	} else {
+		if e.toolkitFlags.WebConfigFile != nil && *e.toolkitFlags.WebConfigFile != "" {
+			if err := watcher.Add(*e.toolkitFlags.WebConfigFile); err != nil {
+				level.Warn(logger).Log("msg", "could not watch web config file for changes", "err", err)
+			}
+		}
+		if e.ziti != nil && e.ziti.config.IdentityFile != nil && *e.ziti.config.IdentityFile != "" {
+			if err := watcher.Add(*e.ziti.config.IdentityFile); err != nil {
+				level.Warn(logger).Log("msg", "could not watch ziti identity file for changes", "err", err)
+			}
+		}
+	}
+
+	go func() {
+		if watcher != nil {
+			defer watcher.Close()
+		}
+		for {
+			select {
+			case <-sighup:
+				level.Info(logger).Log("msg", "received SIGHUP, reloading configuration")
+				e.triggerReload()
+			case event := <-watcherEvents(watcher):
+				level.Info(logger).Log("msg", "detected change of watched configuration file, reloading configuration", "file", event.Name)
+				e.triggerReload()
+			case err := <-watcherErrors(watcher):
+				level.Warn(logger).Log("msg", "error watching configuration files", "err", err)
+			}
+		}
+	}()
+}
+
+// watcherEvents and watcherErrors return nil channels when no watcher is
+// available, which blocks forever in a select and thus never fires - the
+// standard way to make an optional case a no-op.
+func watcherEvents(w *fsnotify.Watcher) chan fsnotify.Event {
+	if w == nil {
+		return nil
+	}
+	return w.Events
+}
+
+func watcherErrors(w *fsnotify.Watcher) chan error {
+	if w == nil {
+		return nil
+	}
+	return w.Errors
+}
+
+// triggerReload notifies the ziti listener goroutine that it should re-read
+// its identity file and re-open its listener. TLS material for the regular
+// web listeners is already re-read from disk on every handshake by
+// exporter-toolkit, so there is nothing to do for those here.
+func (e *ExporterHelper) triggerReload() {
+	select {
+	case e.reloadSignal <- struct{}{}:
+	default:
+		// a reload is already pending, no need to queue another one
+	}
+}
+
+// serveZitiWithReload owns the lifecycle of the ziti listener: it creates
+// it, serves on it, and, whenever a reload is requested via reloadSignal,
+// closes it and opens a new one in its place without returning to the
+// caller - in every mode, including ziti-only, so closing the listener for a
+// reload never takes the process down by itself. If errCh is non-nil
+// (ziti-only mode) the first terminal error is sent there and the loop
+// stops, including a failed createZitiListener call after a reload;
+// otherwise a failed createZitiListener call just waits for the next reload
+// signal before retrying.
+//
+// reloadPending tracks whether the listener about to be (re-)created was
+// requested by a reload, as opposed to the initial startup bind, so the
+// config-reload metrics only count actual reloads, and only once the
+// rebind's outcome - success or failure - is known. SIGHUP and the
+// web-config watch fire in every deployment, including ones with no ziti
+// identity configured at all, where createZitiListener always returns nil;
+// that's not a failed reload, so the failure counter only fires when ziti
+// is actually configured (e.ziti.enabled()) - otherwise a reload is counted
+// as a (trivial) success, since there's nothing ziti-related to re-bind and
+// the web listeners' TLS material is already re-read per handshake.
+func (e *ExporterHelper) serveZitiWithReload(server *http.Server, errCh chan<- error) {
+	logger := e.Logger()
+	var reloadPending bool
+
+	for {
+		listener := e.ziti.createZitiListener(logger)
+		if listener == nil {
+			if reloadPending {
+				if e.ziti.enabled() {
+					configReloadFailureTotal.Inc()
+				} else {
+					configReloadSuccessTotal.Inc()
+					configReloadLastTimestampSeconds.Set(float64(time.Now().Unix()))
+				}
+				reloadPending = false
+			}
+			if errCh != nil {
+				errCh <- errors.New("could not create ziti listener in ziti only mode")
+				return
+			}
+			<-e.reloadSignal
+			reloadPending = true
+			continue
+		}
+
+		if reloadPending {
+			configReloadSuccessTotal.Inc()
+			configReloadLastTimestampSeconds.Set(float64(time.Now().Unix()))
+			reloadPending = false
+		}
+
+		var closedForReload atomic.Bool
+		reloading := make(chan struct{})
+		go func() {
+			select {
+			case <-e.reloadSignal:
+				closedForReload.Store(true)
+				listener.Close()
+			case <-reloading:
+			}
+		}()
+
+		err := web.ServeMultiple([]net.Listener{listener}, server, e.toolkitFlags, logger)
+		close(reloading)
+
+		if closedForReload.Load() {
+			// the listener was closed on purpose to rebind with a fresh
+			// identity/config, in every mode - including ziti-only - so
+			// loop around and do that instead of treating it as fatal.
+			reloadPending = true
+			continue
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) && !isClosedListenerErr(err) {
+			level.Error(logger).Log("msg", "ziti listener stopped unexpectedly", "err", err)
+		}
+
+		if errCh != nil {
+			errCh <- err
+			return
+		}
+	}
+}
+
+// isClosedListenerErr reports whether err is the "use of closed network
+// connection" error net.Listener.Accept returns after Close was called from
+// under it, which is the expected way serveZitiWithReload unblocks a reload.
+func isClosedListenerErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "use of closed")
+}